@@ -0,0 +1,31 @@
+//go:build node
+
+package stitch
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+)
+
+// execEvaluator shells out to a `node` binary on PATH. It's kept around, behind
+// the `node` build tag, for users who need full Node compatibility -- e.g. specs
+// that `require('@quilt/core')` from npm rather than the embedded bindings.
+type execEvaluator struct{}
+
+func newDefaultEvaluator() Evaluator {
+	return execEvaluator{}
+}
+
+func (execEvaluator) Eval(dir, javascript string) ([]byte, error) {
+	cmd := exec.Command("node", "-p", javascript)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return []byte{}, errors.New(stderr.String())
+	}
+	return out, nil
+}
@@ -0,0 +1,39 @@
+//go:build !node
+
+package stitch
+
+import (
+	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/require"
+)
+
+// gojaEvaluator is the default Evaluator: it runs javascript against an embedded,
+// hermetic JS engine instead of shelling out to `node -p`. This makes Quilt usable
+// without a Node install and avoids the ~50ms subprocess startup per invocation,
+// which remains the actual perf target -- not VM reuse.
+//
+// Each Eval call gets its own *goja.Runtime, loading bindings.js fresh every time.
+// A goja.Runtime isn't safe for concurrent use, and require() caches modules for
+// the lifetime of the Runtime, so sharing one across calls would both race and
+// leak the bindings.js deployment singleton (getDeployment()) from one spec's
+// evaluation into the next's.
+//
+// Eval does not use dir: relative requires at the top level of javascript are
+// not resolved against it, which is why runJavascript and FromFile pass
+// absolute paths to require.
+type gojaEvaluator struct{}
+
+func newDefaultEvaluator() Evaluator {
+	return gojaEvaluator{}
+}
+
+func (gojaEvaluator) Eval(dir, javascript string) ([]byte, error) {
+	vm := goja.New()
+	new(require.Registry).Enable(vm)
+
+	v, err := vm.RunString(javascript)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(v.String()), nil
+}
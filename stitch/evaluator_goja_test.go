@@ -0,0 +1,51 @@
+//go:build !node
+
+package stitch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGojaEvaluatorEval(t *testing.T) {
+	e := newDefaultEvaluator()
+
+	out, err := e.Eval(".", "1 + 1")
+	if err != nil {
+		t.Fatalf("Eval: %s", err)
+	}
+	if string(out) != "2" {
+		t.Errorf("Eval(\"1 + 1\") = %q, want \"2\"", out)
+	}
+}
+
+func TestGojaEvaluatorEvalError(t *testing.T) {
+	e := newDefaultEvaluator()
+
+	_, err := e.Eval(".", "throw new Error('boom')")
+	if err == nil {
+		t.Fatal("Eval of a throwing script should return an error")
+	}
+	if !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Eval error %q doesn't surface the original exception message; "+
+			"it must not be masked by an unrelated failure", err)
+	}
+}
+
+func TestGojaEvaluatorIsolatesCalls(t *testing.T) {
+	e := newDefaultEvaluator()
+
+	if _, err := e.Eval(".", "globalThis.x = 1"); err != nil {
+		t.Fatalf("Eval: %s", err)
+	}
+
+	out, err := e.Eval(".", "typeof globalThis.x")
+	if err != nil {
+		t.Fatalf("Eval: %s", err)
+	}
+	if string(out) != "undefined" {
+		t.Errorf("globalThis.x leaked across Eval calls (got %q, want "+
+			"\"undefined\") -- each call must get a fresh VM so one spec's "+
+			"state can't bleed into the next", out)
+	}
+}
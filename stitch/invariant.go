@@ -0,0 +1,90 @@
+package stitch
+
+import "fmt"
+
+// invariantType enumerates the kinds of invariant a spec can assert about its own
+// deployment. reachable, reachableACL, neighbor, and between are declared here
+// because the JS bindings already expose them as invariantType values; only
+// healthy is implemented below -- see the checkInvariants doc comment.
+type invariantType string
+
+// Invariant types understood by checkInvariants.
+const (
+	reachableInvariant    invariantType = "reachable"
+	reachableACLInvariant invariantType = "reachableACL"
+	neighborInvariant     invariantType = "neighbor"
+	betweenInvariant      invariantType = "between"
+	healthyInvariant      invariantType = "healthy"
+)
+
+// An invariant is a single assertion a spec makes about its own deployment,
+// checked by checkInvariants once the rest of the Stitch has been validated.
+// Its fields are exported, unlike most of this file, so encoding/json can
+// populate them from the JSON a real spec unmarshals into Stitch.Invariants.
+type invariant struct {
+	Form   invariantType `json:",omitempty"`
+	Nodes  []string      `json:",omitempty"`
+	Target bool          `json:",omitempty"`
+}
+
+// A Graph is the connectivity view of a Stitch that checkInvariants walks to
+// verify invariants against.
+type Graph struct {
+	stitch Stitch
+}
+
+// InitializeGraph builds the Graph used to check stc's invariants.
+func InitializeGraph(stc Stitch) (Graph, error) {
+	return Graph{stitch: stc}, nil
+}
+
+// checkInvariants verifies every invariant in invariants against graph, failing on
+// the first violation.
+//
+// Only the healthy invariant is implemented here: reachable, reachableACL,
+// neighbor, and between need the full connectivity-graph walk, which isn't part
+// of this tree, so they fail closed with an explicit error instead of silently
+// passing.
+func checkInvariants(graph Graph, invariants []invariant) error {
+	for _, inv := range invariants {
+		switch inv.Form {
+		case healthyInvariant:
+			if err := checkHealthy(graph.stitch, inv); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invariant type %q is not implemented", inv.Form)
+		}
+	}
+	return nil
+}
+
+// checkHealthy enforces a healthy invariant: every container in each of inv's
+// labels must declare a non-empty HealthCheck.
+func checkHealthy(stc Stitch, inv invariant) error {
+	containersByID := make(map[string]Container)
+	for _, c := range stc.Containers {
+		containersByID[c.ID] = c
+	}
+
+	labelByName := make(map[string]Label)
+	for _, l := range stc.Labels {
+		labelByName[l.Name] = l
+	}
+
+	for _, name := range inv.Nodes {
+		label, ok := labelByName[name]
+		if !ok {
+			return fmt.Errorf("healthy: no such label %q", name)
+		}
+		for _, id := range label.IDs {
+			c, ok := containersByID[id]
+			if !ok || len(c.HealthCheck.Test) == 0 {
+				return fmt.Errorf(
+					"healthy: container %q in label %q has no healthcheck",
+					id, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package stitch
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckHealthy(t *testing.T) {
+	stc := Stitch{
+		Labels: []Label{
+			{Name: "web", IDs: []string{"web1"}},
+		},
+		Containers: []Container{
+			{ID: "web1", HealthCheck: HealthCheck{Test: []string{"CMD", "true"}}},
+		},
+	}
+	if err := checkHealthy(stc, invariant{Nodes: []string{"web"}}); err != nil {
+		t.Errorf("checkHealthy() = %s, want nil", err)
+	}
+
+	unhealthy := Stitch{
+		Labels: []Label{
+			{Name: "web", IDs: []string{"web1"}},
+		},
+		Containers: []Container{
+			{ID: "web1"},
+		},
+	}
+	if err := checkHealthy(unhealthy, invariant{Nodes: []string{"web"}}); err == nil {
+		t.Error("checkHealthy() = nil, want error for a container with no healthcheck")
+	}
+
+	if err := checkHealthy(stc, invariant{Nodes: []string{"missing"}}); err == nil {
+		t.Error("checkHealthy() = nil, want error for a nonexistent label")
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	stc := Stitch{
+		Labels: []Label{
+			{Name: "web", IDs: []string{"web1"}},
+		},
+		Containers: []Container{
+			{ID: "web1", HealthCheck: HealthCheck{Test: []string{"CMD", "true"}}},
+		},
+	}
+	graph, err := InitializeGraph(stc)
+	if err != nil {
+		t.Fatalf("InitializeGraph: %s", err)
+	}
+
+	err = checkInvariants(graph, []invariant{{Form: healthyInvariant, Nodes: []string{"web"}}})
+	if err != nil {
+		t.Errorf("checkInvariants() = %s, want nil", err)
+	}
+
+	err = checkInvariants(graph, []invariant{{Form: reachableInvariant}})
+	if err == nil {
+		t.Error("checkInvariants() = nil, want error for an unimplemented invariant type")
+	}
+	if !strings.Contains(err.Error(), "not implemented") {
+		t.Errorf("checkInvariants() error = %q, want it to say the invariant "+
+			"isn't implemented", err)
+	}
+}
+
+// TestInvariantFromBytes drives a healthy invariant through fromBytes, the path a
+// real spec takes, rather than hand-building an invariant struct literal -- so a
+// regression where Invariants' fields come back unmarshaled as zero values (e.g. if
+// they were unexported again) shows up as a test failure here.
+func TestInvariantFromBytes(t *testing.T) {
+	healthy := []byte(`{
+		"Labels": [{"Name": "web", "IDs": ["web1"]}],
+		"Containers": [{"ID": "web1", "HealthCheck": {
+			"Test": ["CMD", "true"], "Interval": 1000000000
+		}}],
+		"Invariants": [{"Form": "healthy", "Nodes": ["web"]}]
+	}`)
+	if _, err := fromBytes(healthy); err != nil {
+		t.Errorf("fromBytes() = %s, want nil for a spec whose containers all "+
+			"have a HealthCheck", err)
+	}
+
+	unhealthy := []byte(`{
+		"Labels": [{"Name": "web", "IDs": ["web1"]}],
+		"Containers": [{"ID": "web1"}],
+		"Invariants": [{"Form": "healthy", "Nodes": ["web"]}]
+	}`)
+	if _, err := fromBytes(unhealthy); err == nil {
+		t.Error("fromBytes() = nil, want error for a healthy invariant whose " +
+			"container has no HealthCheck")
+	}
+}
@@ -0,0 +1,382 @@
+// Package kube lowers a Stitch to a set of Kubernetes manifests, the reverse
+// direction of `podman play-kube`: instead of reading Kubernetes YAML, we emit it
+// for users who want to `kubectl apply` a Quilt deployment.
+package kube
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/NetSys/quilt/stitch"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ToKubernetes lowers stc to the Kubernetes resources that implement it: one
+// Deployment per Label, a Service for each label reachable from the public internet,
+// NetworkPolicies derived from the connection graph, and node selectors/affinities
+// derived from Placement.
+func ToKubernetes(stc stitch.Stitch) ([]runtime.Object, error) {
+	var objs []runtime.Object
+
+	containersByID := make(map[string]stitch.Container)
+	for _, c := range stc.Containers {
+		containersByID[c.ID] = c
+	}
+
+	for _, l := range stc.Labels {
+		dep, err := toDeployment(l, containersByID, stc.Placements)
+		if err != nil {
+			return nil, fmt.Errorf("label %q: %s", l.Name, err)
+		}
+		objs = append(objs, dep)
+	}
+
+	objs = append(objs, toServices(stc.Connections)...)
+
+	objs = append(objs, toNetworkPolicies(stc.Connections)...)
+
+	return objs, nil
+}
+
+// toDeployment builds the Deployment for a Label, with a pod template assembled
+// from the label's containers and node selectors/affinities from Placement.
+func toDeployment(l stitch.Label, containersByID map[string]stitch.Container,
+	placements []stitch.Placement) (*appsv1.Deployment, error) {
+
+	var podContainers []corev1.Container
+	volumes := make(map[string]corev1.Volume)
+	for i, id := range l.IDs {
+		c, ok := containersByID[id]
+		if !ok {
+			return nil, fmt.Errorf("no container with id %q", id)
+		}
+
+		mounts, containerVolumes := toVolumeMounts(i, c.Mounts)
+		for name, v := range containerVolumes {
+			volumes[name] = v
+		}
+
+		podContainers = append(podContainers, corev1.Container{
+			Name:          id,
+			Image:         c.Image.Ref(),
+			Command:       c.Command,
+			Env:           toEnvVars(c.Env),
+			Resources:     toResourceRequirements(c.Resources),
+			VolumeMounts:  mounts,
+			LivenessProbe: toProbe(c.HealthCheck),
+		})
+	}
+
+	var podVolumes []corev1.Volume
+	for _, v := range volumes {
+		podVolumes = append(podVolumes, v)
+	}
+
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{Kind: "Deployment", APIVersion: "apps/v1"},
+		ObjectMeta: metav1.ObjectMeta{Name: l.Name},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"quilt-label": l.Name},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: map[string]string{"quilt-label": l.Name},
+				},
+				Spec: corev1.PodSpec{
+					Containers: podContainers,
+					Volumes:    podVolumes,
+					// A Deployment's pod template only accepts
+					// RestartPolicyAlways; Docker-style per-container
+					// policies (RestartOnFailure, RestartNo, ...) have no
+					// Deployment equivalent, so every label's pod restarts
+					// on exit regardless of its containers' RestartPolicy.
+					RestartPolicy: corev1.RestartPolicyAlways,
+					NodeSelector:  nodeSelector(l.Name, placements),
+					Affinity:      affinity(l.Name, placements),
+				},
+			},
+		},
+	}, nil
+}
+
+// toResourceRequirements converts a Container's Resources caps to Kubernetes
+// limits, mapping only CPU (in cores) and Memory (in bytes). No requests are
+// set, so MemoryReservation is dropped; CPUShares, PidsLimit, and BlkioWeight
+// have no ResourceRequirements equivalent and are dropped too.
+func toResourceRequirements(r stitch.Resources) corev1.ResourceRequirements {
+	limits := corev1.ResourceList{}
+	if r.CPU > 0 {
+		limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(
+			int64(r.CPU*1000), resource.DecimalSI)
+	}
+	if r.Memory > 0 {
+		limits[corev1.ResourceMemory] = *resource.NewQuantity(
+			r.Memory, resource.BinarySI)
+	}
+	if len(limits) == 0 {
+		return corev1.ResourceRequirements{}
+	}
+	return corev1.ResourceRequirements{Limits: limits}
+}
+
+// toVolumeMounts converts a Container's Mounts to Kubernetes VolumeMounts, plus
+// the pod-level Volumes they reference. A Mount whose Source names a declared
+// Volume becomes an EmptyDir (this tree has no real volume backend to bind to);
+// a Mount whose Source is an absolute host path becomes a HostPath volume.
+// containerIdx disambiguates mounts across the pod's containers, since Source
+// on its own isn't guaranteed unique and isn't a valid Kubernetes name.
+func toVolumeMounts(containerIdx int, mounts []stitch.Mount) ([]corev1.VolumeMount, map[string]corev1.Volume) {
+	var volumeMounts []corev1.VolumeMount
+	volumes := make(map[string]corev1.Volume)
+	for i, m := range mounts {
+		name := fmt.Sprintf("vol-%d-%d-%s", containerIdx, i, sanitizeName(m.Source))
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      name,
+			MountPath: m.Target,
+			ReadOnly:  m.ReadOnly,
+		})
+
+		volume := corev1.Volume{Name: name}
+		if filepath.IsAbs(m.Source) {
+			volume.VolumeSource = corev1.VolumeSource{
+				HostPath: &corev1.HostPathVolumeSource{Path: m.Source},
+			}
+		} else {
+			volume.VolumeSource = corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			}
+		}
+		volumes[name] = volume
+	}
+	return volumeMounts, volumes
+}
+
+// nonDNS1123 matches runs of characters not valid in a Kubernetes name
+// (a DNS-1123 label: lowercase alphanumerics and '-').
+var nonDNS1123 = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// sanitizeName lowercases s and replaces every run of characters illegal in a
+// DNS-1123 label with a single '-', so an absolute path like "/host/data"
+// becomes "host-data" instead of producing an invalid Volume/VolumeMount name.
+func sanitizeName(s string) string {
+	return strings.Trim(nonDNS1123.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// toProbe converts a HealthCheck to a Kubernetes exec Probe, following the
+// Docker HEALTHCHECK convention that Test's first element is "CMD" or
+// "CMD-SHELL" and the rest are the command to run. A HealthCheck with no Test
+// produces no Probe.
+func toProbe(h stitch.HealthCheck) *corev1.Probe {
+	if len(h.Test) == 0 {
+		return nil
+	}
+
+	command := h.Test
+	switch command[0] {
+	case "CMD":
+		command = command[1:]
+	case "CMD-SHELL":
+		command = []string{"/bin/sh", "-c", strings.Join(command[1:], " ")}
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: command},
+		},
+		PeriodSeconds:    int32(h.Interval / time.Second),
+		TimeoutSeconds:   int32(h.Timeout / time.Second),
+		FailureThreshold: int32(h.Retries),
+	}
+}
+
+// nodeSelector derives a node selector from the machine-constraint Placements
+// targeting label.
+func nodeSelector(label string, placements []stitch.Placement) map[string]string {
+	selector := map[string]string{}
+	for _, p := range placements {
+		if p.TargetLabel != label || p.OtherLabel != "" {
+			continue
+		}
+		if p.Provider != "" {
+			selector["quilt/provider"] = p.Provider
+		}
+		if p.Region != "" {
+			selector["quilt/region"] = p.Region
+		}
+		if p.Size != "" {
+			selector["quilt/size"] = p.Size
+		}
+	}
+	if len(selector) == 0 {
+		return nil
+	}
+	return selector
+}
+
+// affinity derives pod anti-affinity from Exclusive label Placements targeting
+// label.
+func affinity(label string, placements []stitch.Placement) *corev1.Affinity {
+	var terms []corev1.PodAffinityTerm
+	for _, p := range placements {
+		if p.TargetLabel != label || p.OtherLabel == "" || !p.Exclusive {
+			continue
+		}
+		terms = append(terms, corev1.PodAffinityTerm{
+			LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"quilt-label": p.OtherLabel},
+			},
+			TopologyKey: "kubernetes.io/hostname",
+		})
+	}
+	if len(terms) == 0 {
+		return nil
+	}
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: terms,
+		},
+	}
+}
+
+// toServices exposes every label reachable from the public internet as a
+// Kubernetes Service, aggregating all of a label's public Connections -- which
+// may cover more than one port range -- into a single Service with one
+// ServicePort per port in range.
+func toServices(conns []stitch.Connection) []runtime.Object {
+	byDest := make(map[string][]stitch.Connection)
+	var order []string
+	for _, c := range conns {
+		if c.From != stitch.PublicInternetLabel {
+			continue
+		}
+		if _, ok := byDest[c.To]; !ok {
+			order = append(order, c.To)
+		}
+		byDest[c.To] = append(byDest[c.To], c)
+	}
+
+	var objs []runtime.Object
+	for _, dest := range order {
+		seenPorts := make(map[int32]bool)
+		var ports []corev1.ServicePort
+		for _, c := range byDest[dest] {
+			maxPort := c.MaxPort
+			if maxPort < c.MinPort {
+				maxPort = c.MinPort
+			}
+			for p := c.MinPort; p <= maxPort; p++ {
+				port := int32(p)
+				if seenPorts[port] {
+					continue
+				}
+				seenPorts[port] = true
+				ports = append(ports, corev1.ServicePort{
+					Name:       fmt.Sprintf("port-%d", port),
+					Port:       port,
+					TargetPort: intstr.FromInt(p),
+				})
+			}
+		}
+
+		objs = append(objs, &corev1.Service{
+			TypeMeta:   metav1.TypeMeta{Kind: "Service", APIVersion: "v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: dest},
+			Spec: corev1.ServiceSpec{
+				Selector: map[string]string{"quilt-label": dest},
+				Ports:    ports,
+				Type:     corev1.ServiceTypeLoadBalancer,
+			},
+		})
+	}
+	return objs
+}
+
+// toNetworkPolicies derives a NetworkPolicy per destination label, with one
+// Ingress rule per source label allowing traffic from that source on its own
+// port range -- keeping each source's rule separate (rather than merging all
+// sources and ports into one rule) so a policy never allows a source through
+// on a port it was never granted.
+func toNetworkPolicies(conns []stitch.Connection) []runtime.Object {
+	byDest := make(map[string][]stitch.Connection)
+	var destOrder []string
+	for _, c := range conns {
+		if c.From == stitch.PublicInternetLabel {
+			continue
+		}
+		if _, ok := byDest[c.To]; !ok {
+			destOrder = append(destOrder, c.To)
+		}
+		byDest[c.To] = append(byDest[c.To], c)
+	}
+
+	var objs []runtime.Object
+	for _, dest := range destOrder {
+		bySource := make(map[string][]stitch.Connection)
+		var sourceOrder []string
+		for _, c := range byDest[dest] {
+			if _, ok := bySource[c.From]; !ok {
+				sourceOrder = append(sourceOrder, c.From)
+			}
+			bySource[c.From] = append(bySource[c.From], c)
+		}
+
+		var ingress []networkingv1.NetworkPolicyIngressRule
+		for _, source := range sourceOrder {
+			peer := networkingv1.NetworkPolicyPeer{
+				PodSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{"quilt-label": source},
+				},
+			}
+
+			var ports []networkingv1.NetworkPolicyPort
+			for _, c := range bySource[source] {
+				port := intstr.FromInt(c.MinPort)
+				policyPort := networkingv1.NetworkPolicyPort{Port: &port}
+				if c.MaxPort > c.MinPort {
+					endPort := int32(c.MaxPort)
+					policyPort.EndPort = &endPort
+				}
+				ports = append(ports, policyPort)
+			}
+
+			ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+				From:  []networkingv1.NetworkPolicyPeer{peer},
+				Ports: ports,
+			})
+		}
+
+		objs = append(objs, &networkingv1.NetworkPolicy{
+			TypeMeta:   metav1.TypeMeta{Kind: "NetworkPolicy", APIVersion: "networking.k8s.io/v1"},
+			ObjectMeta: metav1.ObjectMeta{Name: dest},
+			Spec: networkingv1.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{
+					MatchLabels: map[string]string{"quilt-label": dest},
+				},
+				Ingress: ingress,
+			},
+		})
+	}
+	return objs
+}
+
+// toEnvVars converts a Container's Env map to the Kubernetes EnvVar list form.
+func toEnvVars(env map[string]string) []corev1.EnvVar {
+	var vars []corev1.EnvVar
+	for k, v := range env {
+		vars = append(vars, corev1.EnvVar{Name: k, Value: v})
+	}
+	return vars
+}
@@ -0,0 +1,132 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/NetSys/quilt/stitch"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
+func TestToDeployment(t *testing.T) {
+	containersByID := map[string]stitch.Container{
+		"web1": {
+			ID:            "web1",
+			Image:         stitch.Image{Name: "nginx"},
+			Mounts:        []stitch.Mount{{Source: "/host/data", Target: "/data"}},
+			HealthCheck:   stitch.HealthCheck{Test: []string{"CMD", "true"}},
+			Resources:     stitch.Resources{CPU: 1, Memory: 1024},
+			RestartPolicy: stitch.RestartPolicy{Name: stitch.RestartAlways},
+		},
+	}
+	label := stitch.Label{Name: "web", IDs: []string{"web1"}}
+
+	dep, err := toDeployment(label, containersByID, nil)
+	if err != nil {
+		t.Fatalf("toDeployment: %s", err)
+	}
+
+	podSpec := dep.Spec.Template.Spec
+	if len(podSpec.Containers) != 1 {
+		t.Fatalf("got %d containers, want 1", len(podSpec.Containers))
+	}
+
+	c := podSpec.Containers[0]
+	if c.LivenessProbe == nil {
+		t.Error("LivenessProbe is nil, want a probe derived from the HealthCheck")
+	}
+	if len(c.VolumeMounts) != 1 {
+		t.Errorf("got %d VolumeMounts, want 1", len(c.VolumeMounts))
+	}
+	if len(podSpec.Volumes) != 1 {
+		t.Errorf("got %d pod Volumes, want 1", len(podSpec.Volumes))
+	}
+	if podSpec.Volumes[0].HostPath == nil {
+		t.Error("an absolute Mount.Source should produce a HostPath volume")
+	}
+	if name := podSpec.Volumes[0].Name; nonDNS1123.MatchString(name) {
+		t.Errorf("Volume name %q is not a valid DNS-1123 label", name)
+	}
+	if podSpec.RestartPolicy != corev1.RestartPolicyAlways {
+		t.Errorf("RestartPolicy = %s, want %s", podSpec.RestartPolicy,
+			corev1.RestartPolicyAlways)
+	}
+	if *dep.Spec.Replicas != 1 {
+		t.Errorf("Replicas = %d, want 1 (the label's containers already form "+
+			"one pod template)", *dep.Spec.Replicas)
+	}
+
+	if _, err := toDeployment(stitch.Label{IDs: []string{"missing"}},
+		containersByID, nil); err == nil {
+		t.Error("toDeployment() = nil error, want error for an unknown container id")
+	}
+}
+
+func TestToServices(t *testing.T) {
+	conns := []stitch.Connection{
+		{From: stitch.PublicInternetLabel, To: "web", MinPort: 80, MaxPort: 82},
+		{From: stitch.PublicInternetLabel, To: "web", MinPort: 81, MaxPort: 81},
+		{From: "other", To: "web", MinPort: 90, MaxPort: 90},
+	}
+
+	objs := toServices(conns)
+	if len(objs) != 1 {
+		t.Fatalf("got %d Services, want 1 (one per destination label)", len(objs))
+	}
+
+	svc := objs[0].(*corev1.Service)
+	if svc.ObjectMeta.Name != "web" {
+		t.Errorf("Service name = %q, want %q", svc.ObjectMeta.Name, "web")
+	}
+	if len(svc.Spec.Ports) != 3 {
+		t.Errorf("got %d ServicePorts, want 3 (80, 81, 82, deduped across "+
+			"overlapping connections)", len(svc.Spec.Ports))
+	}
+}
+
+func TestToNetworkPolicies(t *testing.T) {
+	conns := []stitch.Connection{
+		{From: "web", To: "db", MinPort: 5432, MaxPort: 5434},
+		{From: "api", To: "db", MinPort: 8080, MaxPort: 8080},
+		{From: stitch.PublicInternetLabel, To: "db", MinPort: 1, MaxPort: 1},
+	}
+
+	objs := toNetworkPolicies(conns)
+	if len(objs) != 1 {
+		t.Fatalf("got %d NetworkPolicies, want 1 (public connections don't "+
+			"get an Ingress rule)", len(objs))
+	}
+
+	policy := objs[0].(*networkingv1.NetworkPolicy)
+	ingress := policy.Spec.Ingress
+	if len(ingress) != 2 {
+		t.Fatalf("got %d Ingress rules, want 2 (one per source label, so web "+
+			"can't reach db on api's port and vice versa)", len(ingress))
+	}
+
+	for _, rule := range ingress {
+		if len(rule.From) != 1 {
+			t.Fatalf("got %d peers in one rule, want 1", len(rule.From))
+		}
+		source := rule.From[0].PodSelector.MatchLabels["quilt-label"]
+		ports := rule.Ports
+		if len(ports) != 1 {
+			t.Fatalf("got %d NetworkPolicyPorts for %q, want 1", len(ports), source)
+		}
+		switch source {
+		case "web":
+			if ports[0].EndPort == nil || *ports[0].EndPort != 5434 {
+				t.Errorf("web EndPort = %v, want 5434 to cover its full port range",
+					ports[0].EndPort)
+			}
+		case "api":
+			if ports[0].EndPort != nil {
+				t.Errorf("api EndPort = %v, want nil (single port, no range)",
+					ports[0].EndPort)
+			}
+		default:
+			t.Errorf("unexpected source label %q", source)
+		}
+	}
+}
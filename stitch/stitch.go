@@ -3,12 +3,15 @@
 package stitch
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
-	"os"
-	"os/exec"
+	"fmt"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"time"
 )
 
 // A Stitch is an abstract representation of the policy language.
@@ -24,6 +27,19 @@ type Stitch struct {
 	Namespace string   `json:",omitempty"`
 
 	Invariants []invariant `json:",omitempty"`
+
+	Volumes []Volume `json:",omitempty"`
+}
+
+// A Volume is a named store that a Container can mount, independent of the
+// lifetime of any single container.
+type Volume struct {
+	Name string `json:",omitempty"`
+
+	// Shared marks a Volume as backed by shared/networked storage rather than
+	// the local host, so containers mounting it don't need to be colocated on
+	// the same machine. createVolumeRules skips Colocate placements for these.
+	Shared bool `json:",omitempty"`
 }
 
 // A Placement constraint guides where containers may be scheduled, either relative to
@@ -33,6 +49,10 @@ type Placement struct {
 
 	Exclusive bool `json:",omitempty"`
 
+	// Colocate requires TargetLabel and OtherLabel to be placed on the same
+	// machine, e.g. because they share a non-shared Volume.
+	Colocate bool `json:",omitempty"`
+
 	// Label Constraint
 	OtherLabel string `json:",omitempty"`
 
@@ -48,6 +68,45 @@ type Placement struct {
 type Image struct {
 	Name       string `json:",omitempty"`
 	Dockerfile string `json:",omitempty"`
+
+	Registry   string     `json:",omitempty"`
+	AuthConfig AuthConfig `json:",omitempty"`
+
+	// Digest pins Name to a specific content hash (sha256:...) so Quilt never
+	// silently upgrades the running image.
+	Digest string `json:",omitempty"`
+}
+
+// AuthConfig holds the credentials needed to pull an Image from a private registry,
+// drawing on the Docker registry auth model.
+type AuthConfig struct {
+	Username      string `json:",omitempty"`
+	Password      string `json:",omitempty"`
+	IdentityToken string `json:",omitempty"`
+	ServerAddress string `json:",omitempty"`
+}
+
+// Ref returns the image reference Quilt should deploy: pinned to Digest when one is
+// set, or Name otherwise.
+func (img Image) Ref() string {
+	if img.Digest == "" {
+		return img.Name
+	}
+	return img.Name + "@" + img.Digest
+}
+
+// imageAlias has the same fields as Image, but without its MarshalJSON method, so
+// MarshalJSON can marshal through it without recursing.
+type imageAlias Image
+
+// MarshalJSON folds Ref() into Name and drops Digest, so the deployment
+// representation -- not just consumers like the kube package -- always carries the
+// image pinned to its digest when one is set.
+func (img Image) MarshalJSON() ([]byte, error) {
+	alias := imageAlias(img)
+	alias.Name = img.Ref()
+	alias.Digest = ""
+	return json.Marshal(alias)
 }
 
 // A Container may be instantiated in the stitch and queried by users.
@@ -58,6 +117,67 @@ type Container struct {
 	Env               map[string]string `json:",omitempty"`
 	FilepathToContent map[string]string `json:",omitempty"`
 	Hostname          string            `json:",omitempty"`
+	Resources         Resources         `json:",omitempty"`
+	Mounts            []Mount           `json:",omitempty"`
+	HealthCheck       HealthCheck       `json:",omitempty"`
+	RestartPolicy     RestartPolicy     `json:",omitempty"`
+
+	// Init runs a PID-1 reaper (e.g. tini) in front of Command, so that children
+	// spawned by the container's process don't leak as zombies.
+	Init *bool `json:",omitempty"`
+
+	// StopSignal is sent to the container's process on stop, as a signal name
+	// (e.g. "SIGTERM") or a numeric signal. It defaults to SIGTERM.
+	StopSignal string `json:",omitempty"`
+
+	// StopTimeout is how long, in seconds, to wait after StopSignal before the
+	// runtime sends SIGKILL.
+	StopTimeout int `json:",omitempty"`
+}
+
+// A HealthCheck defines how to probe a Container to determine whether it's healthy,
+// matching the shape of the Docker client's Config.Healthcheck.
+type HealthCheck struct {
+	Test        []string      `json:",omitempty"`
+	Interval    time.Duration `json:",omitempty"`
+	Timeout     time.Duration `json:",omitempty"`
+	Retries     int           `json:",omitempty"`
+	StartPeriod time.Duration `json:",omitempty"`
+}
+
+// A RestartPolicy tells the runtime how to react when a Container exits, matching
+// the Docker client's HostConfig.RestartPolicy.
+type RestartPolicy struct {
+	Name              string `json:",omitempty"`
+	MaximumRetryCount int    `json:",omitempty"`
+}
+
+// Restart policy names recognized by RestartPolicy.Name.
+const (
+	RestartNo            = "no"
+	RestartOnFailure     = "on-failure"
+	RestartAlways        = "always"
+	RestartUnlessStopped = "unless-stopped"
+)
+
+// A Mount attaches a Volume, or an absolute path on the host, to a path inside a
+// Container, mirroring the Docker HostConfig.Binds/Mounts and Podman volume APIs.
+type Mount struct {
+	Target      string `json:",omitempty"`
+	Source      string `json:",omitempty"`
+	ReadOnly    bool   `json:",omitempty"`
+	Propagation string `json:",omitempty"`
+}
+
+// Resources caps the CPU, memory, and other host resources a Container may consume,
+// mirroring the resource knobs exposed by the Docker/Podman HostConfig.
+type Resources struct {
+	CPUShares         int     `json:",omitempty"`
+	CPU               float64 `json:",omitempty"`
+	Memory            int64   `json:",omitempty"`
+	MemoryReservation int64   `json:",omitempty"`
+	PidsLimit         int     `json:",omitempty"`
+	BlkioWeight       int     `json:",omitempty"`
 }
 
 // A Label represents a logical group of containers.
@@ -81,12 +201,17 @@ type ConnectionSlice []Connection
 
 // A Machine specifies the type of VM that should be booted.
 type Machine struct {
-	ID         string   `json:",omitempty"`
-	Provider   string   `json:",omitempty"`
-	Role       string   `json:",omitempty"`
-	Size       string   `json:",omitempty"`
-	CPU        Range    `json:",omitempty"`
-	RAM        Range    `json:",omitempty"`
+	ID       string `json:",omitempty"`
+	Provider string `json:",omitempty"`
+	Role     string `json:",omitempty"`
+	Size     string `json:",omitempty"`
+	CPU      Range  `json:",omitempty"`
+
+	// RAM is in GiB, matching the units cloud providers advertise machine sizes
+	// in; FitsResources converts a Container's byte-valued Resources.Memory to
+	// GiB before comparing against it.
+	RAM Range `json:",omitempty"`
+
 	DiskSize   int      `json:",omitempty"`
 	Region     string   `json:",omitempty"`
 	SSHKeys    []string `json:",omitempty"`
@@ -109,23 +234,38 @@ func (stitchr Range) Accepts(x float64) bool {
 	return stitchr.Min <= x && (stitchr.Max == 0 || x <= stitchr.Max)
 }
 
-// `run` evaluates `javascript` in Node.js and returns the output.
-func run(javascript string) ([]byte, error) {
-	cmd := exec.Command("node", "-p", javascript)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	out, err := cmd.Output()
-	if err != nil {
-		return []byte{}, errors.New(stderr.String())
+// AcceptsSum returns true if the sum of `xs` is within the range specified by
+// `stitchr`. It's used to bin-pack the resource reservations of the containers
+// placed on a machine against that machine's CPU and RAM ranges.
+func (stitchr Range) AcceptsSum(xs []float64) bool {
+	var sum float64
+	for _, x := range xs {
+		sum += x
 	}
-	return out, nil
+	return stitchr.Accepts(sum)
 }
 
-// TODO: This function will become unnecessary when we move all Stitch unit tests
-// to Node.js. Then we can clean up the functions around this.
-func runJavascript(javascript string) ([]byte, error) {
-	return run(`const {
+// An Evaluator runs a snippet of javascript, rooted at `dir` for the purposes of
+// resolving relative `require`s, and returns its output.
+type Evaluator interface {
+	Eval(dir, javascript string) ([]byte, error)
+}
+
+// evaluator is used by FromJavascript and FromFile to run javascript. It defaults
+// to an embedded, hermetic JS engine, avoiding Node's subprocess-startup cost on
+// every call; build with the `node` tag to fall back to shelling out to Node,
+// needed for specs that `require('@quilt/core')`.
+var evaluator = newDefaultEvaluator()
+
+// bindingsHeader returns the javascript that destructures the bindings.js API out
+// of the local, absolute-path bindings.js next to this source file, so both
+// runJavascript and FromFile work against the embedded engine without relying on
+// node_modules resolution.
+func bindingsHeader() string {
+	_, thisFile, _, _ := runtime.Caller(1)
+	bindings := filepath.Join(filepath.Dir(thisFile), "bindings.js")
+
+	return `const {
     Assertion,
     Connection,
     Container,
@@ -151,14 +291,15 @@ func runJavascript(javascript string) ([]byte, error) {
     reachable,
     reachableACL,
     read,
-} = require('./bindings.js');
-try {` +
-		javascript +
-		`;
-} catch (e) {
-    process.stderr.write(e);
-    process.exit(1);
-}`)
+} = require(` + strconv.Quote(bindings) + `);
+`
+}
+
+// TODO: This function will become unnecessary when we move all Stitch unit tests
+// to Node.js. Then we can clean up the functions around this.
+func runJavascript(javascript string) ([]byte, error) {
+	_, thisFile, _, _ := runtime.Caller(0)
+	return evaluator.Eval(filepath.Dir(thisFile), bindingsHeader()+javascript+`;`)
 }
 
 // TODO: Better name.
@@ -169,6 +310,23 @@ func fromBytes(bytes []byte) (stc Stitch, err error) {
 	}
 	stc.createPortRules()
 
+	if err := stc.validateMounts(); err != nil {
+		return Stitch{}, err
+	}
+	stc.createVolumeRules()
+
+	if err := stc.validateHealthChecks(); err != nil {
+		return Stitch{}, err
+	}
+
+	if err := stc.validateImages(); err != nil {
+		return Stitch{}, err
+	}
+
+	if err := stc.validateStopSignals(); err != nil {
+		return Stitch{}, err
+	}
+
 	if len(stc.Invariants) == 0 {
 		return stc, nil
 	}
@@ -197,33 +355,19 @@ func FromJavascript(specStr string) (Stitch, error) {
 
 // FromFile gets a Stitch handle from a file on disk.
 func FromFile(filename string) (Stitch, error) {
-	// Change working directory to load the correct Deployment instance.
-	wd, err := os.Getwd()
-	if err != nil {
-		return Stitch{}, err
-	}
-	err = os.Chdir(filepath.Dir(filename))
+	abs, err := filepath.Abs(filename)
 	if err != nil {
 		return Stitch{}, err
 	}
+	dir := filepath.Dir(abs)
 
-	out, err := run("const { getDeployment } = require('@quilt/core');" +
-		"require('./" + filename + "');" +
+	out, err := evaluator.Eval(dir, bindingsHeader()+
+		"require("+strconv.Quote(abs)+");"+
 		"JSON.stringify(getDeployment().toQuiltRepresentation());")
 	if err != nil {
 		return Stitch{}, err
 	}
-	stc, err := fromBytes(out)
-	if err != nil {
-		return Stitch{}, err
-	}
-
-	// Restore original working directory.
-	err = os.Chdir(wd)
-	if err != nil {
-		return Stitch{}, err
-	}
-	return stc, nil
+	return fromBytes(out)
 }
 
 // FromJSON gets a Stitch handle from the deployment representation.
@@ -259,6 +403,197 @@ func (stitch *Stitch) createPortRules() {
 	}
 }
 
+// bytesPerGiB converts a byte-valued Resources.Memory into the GiB that
+// Machine.RAM is expressed in.
+const bytesPerGiB = 1 << 30
+
+// FitsResources returns true if `machine`'s CPU and RAM ranges can satisfy the sum
+// of the resource reservations of `containers`, turning the machine-only
+// Range.Accepts into a bin-packing check across everything placed there.
+func FitsResources(machine Machine, containers []Container) bool {
+	var cpus, rams []float64
+	for _, c := range containers {
+		cpus = append(cpus, c.Resources.CPU)
+		rams = append(rams, float64(c.Resources.Memory)/bytesPerGiB)
+	}
+	return machine.CPU.AcceptsSum(cpus) && machine.RAM.AcceptsSum(rams)
+}
+
+// validateMounts checks that every Mount.Source on every container resolves to
+// either a declared Volume or an absolute host path.
+func (stitch Stitch) validateMounts() error {
+	volumes := make(map[string]bool)
+	for _, v := range stitch.Volumes {
+		volumes[v.Name] = true
+	}
+
+	for _, c := range stitch.Containers {
+		for _, m := range c.Mounts {
+			if volumes[m.Source] || filepath.IsAbs(m.Source) {
+				continue
+			}
+			return fmt.Errorf("mount source %q is neither a declared volume nor "+
+				"an absolute path", m.Source)
+		}
+	}
+	return nil
+}
+
+// createVolumeRules forces containers that share a volume not declared shared onto
+// the same machine, next to the similar createPortRules.
+func (stitch *Stitch) createVolumeRules() {
+	shared := make(map[string]bool)
+	for _, v := range stitch.Volumes {
+		if v.Shared {
+			shared[v.Name] = true
+		}
+	}
+
+	labelOf := make(map[string]string)
+	for _, l := range stitch.Labels {
+		for _, id := range l.IDs {
+			labelOf[id] = l.Name
+		}
+	}
+
+	volumeToLabels := make(map[string][]string)
+	seen := make(map[string]map[string]bool)
+	for _, c := range stitch.Containers {
+		label, ok := labelOf[c.ID]
+		if !ok {
+			continue
+		}
+		for _, m := range c.Mounts {
+			if shared[m.Source] {
+				continue
+			}
+			if seen[m.Source] == nil {
+				seen[m.Source] = make(map[string]bool)
+			}
+			if seen[m.Source][label] {
+				continue
+			}
+			seen[m.Source][label] = true
+			volumeToLabels[m.Source] = append(volumeToLabels[m.Source], label)
+		}
+	}
+
+	for _, labels := range volumeToLabels {
+		for _, tgt := range labels {
+			for _, other := range labels {
+				if tgt == other {
+					continue
+				}
+				stitch.Placements = append(stitch.Placements,
+					Placement{
+						Colocate:    true,
+						TargetLabel: tgt,
+						OtherLabel:  other,
+					})
+			}
+		}
+	}
+}
+
+// validateHealthChecks checks that every declared HealthCheck has a non-empty Test,
+// a positive Interval, and non-negative Timeout/StartPeriod (0 means "use the
+// runtime's default", matching Docker's HealthConfig semantics).
+func (stitch Stitch) validateHealthChecks() error {
+	for _, c := range stitch.Containers {
+		hc := c.HealthCheck
+		if len(hc.Test) == 0 && hc.Interval == 0 && hc.Timeout == 0 &&
+			hc.Retries == 0 && hc.StartPeriod == 0 {
+			continue
+		}
+
+		if len(hc.Test) == 0 {
+			return errors.New("healthcheck requires a non-empty Test command")
+		}
+		if hc.Interval <= 0 {
+			return fmt.Errorf("healthcheck Interval must be positive: %+v", hc)
+		}
+		if hc.Timeout < 0 || hc.StartPeriod < 0 {
+			return fmt.Errorf("healthcheck durations must not be negative: %+v", hc)
+		}
+	}
+	return nil
+}
+
+// digestPattern matches a sha256 content digest: "sha256:" followed by 64 lowercase
+// hex characters. Digests using other algorithms are not accepted.
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// validateImages checks that no container Image specifies both a Dockerfile and a
+// Digest, since Quilt can't pin a hash for an image it hasn't built yet, and that any
+// Digest that is set is a well-formed sha256 digest, since Ref() embeds it verbatim
+// in the image reference Quilt deploys.
+func (stitch Stitch) validateImages() error {
+	for _, c := range stitch.Containers {
+		if c.Image.Dockerfile != "" && c.Image.Digest != "" {
+			return fmt.Errorf("image %q specifies both a Dockerfile and a Digest",
+				c.Image.Name)
+		}
+		if c.Image.Digest != "" && !digestPattern.MatchString(c.Image.Digest) {
+			return fmt.Errorf("image %q has malformed Digest %q, want sha256:<hex>",
+				c.Image.Name, c.Image.Digest)
+		}
+	}
+	return nil
+}
+
+// An ImageResolver looks up the content digest for an image in a registry, so that
+// ResolveImages can pin images that don't already specify one.
+type ImageResolver interface {
+	Resolve(ctx context.Context, image Image) (digest string, err error)
+}
+
+// ResolveImages walks every container in the Stitch and fills in the Digest of any
+// Image that doesn't already have one, using resolver to query the registry. This
+// lets callers turn a floating tag into a reproducible, digest-pinned deployment.
+func (stitch *Stitch) ResolveImages(ctx context.Context, resolver ImageResolver) error {
+	for i, c := range stitch.Containers {
+		if c.Image.Digest != "" {
+			continue
+		}
+
+		digest, err := resolver.Resolve(ctx, c.Image)
+		if err != nil {
+			return fmt.Errorf("resolve digest for %q: %s", c.Image.Name, err)
+		}
+		stitch.Containers[i].Image.Digest = digest
+	}
+	return nil
+}
+
+// signalNames are the signal names accepted by Container.StopSignal: a subset of
+// what Docker/Podman accept for --stop-signal, each written with its SIG prefix.
+var signalNames = map[string]bool{
+	"SIGABRT": true, "SIGALRM": true, "SIGBUS": true, "SIGCHLD": true,
+	"SIGCONT": true, "SIGFPE": true, "SIGHUP": true, "SIGILL": true,
+	"SIGINT": true, "SIGKILL": true, "SIGPIPE": true, "SIGQUIT": true,
+	"SIGSEGV": true, "SIGSTOP": true, "SIGTERM": true, "SIGTSTP": true,
+	"SIGTTIN": true, "SIGTTOU": true, "SIGUSR1": true, "SIGUSR2": true,
+}
+
+// validateStopSignals checks that every declared Container.StopSignal is either a
+// known signal name or a numeric signal.
+func (stitch Stitch) validateStopSignals() error {
+	for _, c := range stitch.Containers {
+		if c.StopSignal == "" {
+			continue
+		}
+		if signalNames[c.StopSignal] {
+			continue
+		}
+		if n, err := strconv.Atoi(c.StopSignal); err == nil && n > 0 {
+			continue
+		}
+		return fmt.Errorf("container %q: unrecognized stop signal %q",
+			c.ID, c.StopSignal)
+	}
+	return nil
+}
+
 // String returns the Stitch in its deployment representation.
 func (stitch Stitch) String() string {
 	jsonBytes, err := json.Marshal(stitch)
@@ -0,0 +1,234 @@
+package stitch
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRangeAcceptsSum(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Range
+		xs   []float64
+		want bool
+	}{
+		{"within bounded range", Range{Min: 1, Max: 4}, []float64{1, 2}, true},
+		{"sum below min", Range{Min: 4, Max: 8}, []float64{1, 2}, false},
+		{"sum above max", Range{Min: 0, Max: 2}, []float64{1, 2}, false},
+		{"no max, sum above min", Range{Min: 1}, []float64{10, 20}, true},
+		{"empty sum", Range{Min: 0, Max: 1}, nil, true},
+	}
+
+	for _, test := range tests {
+		if got := test.r.AcceptsSum(test.xs); got != test.want {
+			t.Errorf("%s: AcceptsSum(%v) = %t, want %t",
+				test.name, test.xs, got, test.want)
+		}
+	}
+}
+
+func TestFitsResources(t *testing.T) {
+	const giB = 1 << 30
+
+	machine := Machine{
+		CPU: Range{Min: 0, Max: 2},
+		RAM: Range{Min: 0, Max: 4}, // 4GiB
+	}
+
+	fits := []Container{
+		{Resources: Resources{CPU: 1, Memory: 2 * giB}},
+		{Resources: Resources{CPU: 0.5, Memory: giB}},
+	}
+	if !FitsResources(machine, fits) {
+		t.Errorf("FitsResources(%v, %v) = false, want true", machine, fits)
+	}
+
+	tooMuchCPU := []Container{
+		{Resources: Resources{CPU: 1.5, Memory: giB}},
+		{Resources: Resources{CPU: 1, Memory: giB}},
+	}
+	if FitsResources(machine, tooMuchCPU) {
+		t.Errorf("FitsResources(%v, %v) = true, want false (CPU oversubscribed)",
+			machine, tooMuchCPU)
+	}
+
+	tooMuchRAM := []Container{
+		{Resources: Resources{CPU: 1, Memory: 8 * giB}},
+	}
+	if FitsResources(machine, tooMuchRAM) {
+		t.Errorf("FitsResources(%v, %v) = true, want false (RAM oversubscribed)",
+			machine, tooMuchRAM)
+	}
+}
+
+func TestValidateMounts(t *testing.T) {
+	stitch := Stitch{
+		Volumes: []Volume{{Name: "data"}},
+		Containers: []Container{
+			{ID: "a", Mounts: []Mount{{Source: "data", Target: "/data"}}},
+			{ID: "b", Mounts: []Mount{{Source: "/host/path", Target: "/data"}}},
+		},
+	}
+	if err := stitch.validateMounts(); err != nil {
+		t.Errorf("validateMounts() = %s, want nil", err)
+	}
+
+	bad := Stitch{
+		Containers: []Container{
+			{ID: "a", Mounts: []Mount{{Source: "nonexistent", Target: "/data"}}},
+		},
+	}
+	if err := bad.validateMounts(); err == nil {
+		t.Error("validateMounts() = nil, want error for an undeclared, " +
+			"non-absolute mount source")
+	}
+}
+
+func TestCreateVolumeRules(t *testing.T) {
+	stitch := Stitch{
+		Volumes: []Volume{{Name: "data"}},
+		Labels: []Label{
+			{Name: "foo", IDs: []string{"foo1"}},
+			{Name: "bar", IDs: []string{"bar1"}},
+		},
+		Containers: []Container{
+			{ID: "foo1", Mounts: []Mount{{Source: "data"}}},
+			{ID: "bar1", Mounts: []Mount{{Source: "data"}}},
+		},
+	}
+	stitch.createVolumeRules()
+
+	if len(stitch.Placements) != 2 {
+		t.Fatalf("createVolumeRules() produced %d placements, want 2 (foo->bar "+
+			"and bar->foo)", len(stitch.Placements))
+	}
+	for _, p := range stitch.Placements {
+		if !p.Colocate {
+			t.Errorf("placement %+v is not a Colocate placement", p)
+		}
+	}
+}
+
+func TestCreateVolumeRulesShared(t *testing.T) {
+	stitch := Stitch{
+		Volumes: []Volume{{Name: "data", Shared: true}},
+		Labels: []Label{
+			{Name: "foo", IDs: []string{"foo1"}},
+			{Name: "bar", IDs: []string{"bar1"}},
+		},
+		Containers: []Container{
+			{ID: "foo1", Mounts: []Mount{{Source: "data"}}},
+			{ID: "bar1", Mounts: []Mount{{Source: "data"}}},
+		},
+	}
+	stitch.createVolumeRules()
+
+	if len(stitch.Placements) != 0 {
+		t.Errorf("createVolumeRules() produced %d placements for a shared "+
+			"volume, want 0", len(stitch.Placements))
+	}
+}
+
+func TestCreateVolumeRulesDedup(t *testing.T) {
+	stitch := Stitch{
+		Volumes: []Volume{{Name: "data"}},
+		Labels: []Label{
+			{Name: "foo", IDs: []string{"foo1", "foo2"}},
+			{Name: "bar", IDs: []string{"bar1", "bar2"}},
+		},
+		Containers: []Container{
+			{ID: "foo1", Mounts: []Mount{{Source: "data"}}},
+			{ID: "foo2", Mounts: []Mount{{Source: "data"}}},
+			{ID: "bar1", Mounts: []Mount{{Source: "data"}}},
+			{ID: "bar2", Mounts: []Mount{{Source: "data"}}},
+		},
+	}
+	stitch.createVolumeRules()
+
+	if len(stitch.Placements) != 2 {
+		t.Fatalf("createVolumeRules() produced %d placements, want 2 (foo->bar "+
+			"and bar->foo, deduped even though each label has 2 containers "+
+			"mounting the volume)", len(stitch.Placements))
+	}
+}
+
+func TestValidateHealthChecks(t *testing.T) {
+	ok := Stitch{Containers: []Container{
+		{HealthCheck: HealthCheck{Test: []string{"CMD", "true"}, Interval: time.Second}},
+	}}
+	if err := ok.validateHealthChecks(); err != nil {
+		t.Errorf("validateHealthChecks() = %s, want nil", err)
+	}
+
+	noTest := Stitch{Containers: []Container{
+		{HealthCheck: HealthCheck{Interval: time.Second}},
+	}}
+	if err := noTest.validateHealthChecks(); err == nil {
+		t.Error("validateHealthChecks() = nil, want error for an empty Test " +
+			"with a non-zero Interval")
+	}
+
+	negativeDuration := Stitch{Containers: []Container{
+		{HealthCheck: HealthCheck{Test: []string{"CMD", "true"}, Interval: -time.Second}},
+	}}
+	if err := negativeDuration.validateHealthChecks(); err == nil {
+		t.Error("validateHealthChecks() = nil, want error for a negative Interval")
+	}
+
+	zeroInterval := Stitch{Containers: []Container{
+		{HealthCheck: HealthCheck{Test: []string{"CMD", "true"}}},
+	}}
+	if err := zeroInterval.validateHealthChecks(); err == nil {
+		t.Error("validateHealthChecks() = nil, want error for a zero Interval " +
+			"with a Test set")
+	}
+}
+
+func TestImageMarshalJSON(t *testing.T) {
+	img := Image{
+		Name:   "nginx",
+		Digest: "sha256:" + strings.Repeat("a", 64),
+	}
+
+	out, err := json.Marshal(img)
+	if err != nil {
+		t.Fatalf("json.Marshal: %s", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatalf("json.Unmarshal: %s", err)
+	}
+	if got["Name"] != img.Ref() {
+		t.Errorf(`marshaled Name = %q, want Ref() %q`, got["Name"], img.Ref())
+	}
+	if _, ok := got["Digest"]; ok {
+		t.Errorf("marshaled output has a Digest field, want it folded into Name")
+	}
+}
+
+func TestValidateImages(t *testing.T) {
+	ok := Stitch{Containers: []Container{
+		{Image: Image{Name: "nginx", Digest: "sha256:" + strings.Repeat("a", 64)}},
+	}}
+	if err := ok.validateImages(); err != nil {
+		t.Errorf("validateImages() = %s, want nil", err)
+	}
+
+	both := Stitch{Containers: []Container{
+		{Image: Image{Name: "nginx", Dockerfile: "FROM nginx",
+			Digest: "sha256:" + strings.Repeat("a", 64)}},
+	}}
+	if err := both.validateImages(); err == nil {
+		t.Error("validateImages() = nil, want error for a Dockerfile with a Digest")
+	}
+
+	malformed := Stitch{Containers: []Container{
+		{Image: Image{Name: "nginx", Digest: "sha256:garbage"}},
+	}}
+	if err := malformed.validateImages(); err == nil {
+		t.Error("validateImages() = nil, want error for a malformed Digest")
+	}
+}